@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ACLRule разрешает identity вызывать один из Methods на любом пути с префиксом
+// Prefix (например, Prefix "/v1/keys/app1/" разрешает alice читать только ключи
+// приложения app1).
+type ACLRule struct {
+	Prefix  string   `json:"prefix" yaml:"prefix"`
+	Methods []string `json:"methods" yaml:"methods"`
+}
+
+// Identity - вызывающий, аутентифицированный authMiddleware: либо через
+// Authorization: Bearer <token>, либо через HTTP Basic.
+type Identity struct {
+	Name  string `json:"name"`
+	Admin bool   `json:"admin"`
+}
+
+// ACLSource - опциональный интерфейс, реализуемый теми TransactionLogger,
+// которые умеют хранить ACL-правила рядом с данными (на данный момент - только
+// PostgresTransactionLogger, таблица acls). Когда logger его не реализует,
+// используются правила из Config.Auth.
+type ACLSource interface {
+	LoadACLs() (acls map[string][]ACLRule, admins []string, err error)
+}
+
+// activeACLs - ACL-правила, с которыми в данный момент запущен сервер
+// (config.Auth.ACLs либо то, что вернул ACLSource - см. main()). bulk-обработчики
+// читают ее напрямую, потому что их path-based проверка в authMiddleware не
+// видит ключи, лежащие в теле запроса (см. pathAuthorized).
+var activeACLs map[string][]ACLRule
+
+type identityContextKey struct{}
+
+// identityFromContext возвращает Identity, помещенный в контекст запроса
+// authMiddleware.
+func identityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityContextKey{}).(Identity)
+	return identity, ok
+}
+
+// authMiddleware аутентифицирует запрос по bearer-токену или HTTP Basic,
+// проверяет identity по ACL и либо пропускает запрос дальше с Identity в
+// контексте, либо отвечает 401 (не аутентифицирован) или 403 (аутентифицирован,
+// но не авторизован для этого пути/метода).
+func authMiddleware(auth AuthConfig, next http.Handler) http.Handler {
+	admins := make(map[string]bool, len(auth.Admins))
+	for _, name := range auth.Admins {
+		admins[name] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := authenticate(r, auth, admins)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="kvstore"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !identity.Admin && !authorized(identity, r, auth.ACLs) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), identityContextKey{}, identity)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticate извлекает Identity из Authorization: Bearer <token> или из HTTP
+// Basic, сверяя его с auth.Tokens/auth.BasicUsers.
+func authenticate(r *http.Request, auth AuthConfig, admins map[string]bool) (Identity, bool) {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token := strings.TrimPrefix(header, "Bearer ")
+		name, ok := auth.Tokens[token]
+		if !ok {
+			return Identity{}, false
+		}
+		return Identity{Name: name, Admin: admins[name]}, true
+	}
+
+	if user, password, ok := r.BasicAuth(); ok {
+		expected, exists := auth.BasicUsers[user]
+		// Сравнение за постоянное время - иначе время ответа по байтам "течет"
+		// через раннее несовпадение в strings.Compare/==, позволяя подобрать
+		// пароль по таймингу.
+		if !exists || subtle.ConstantTimeCompare([]byte(expected), []byte(password)) != 1 {
+			return Identity{}, false
+		}
+		return Identity{Name: user, Admin: admins[user]}, true
+	}
+
+	return Identity{}, false
+}
+
+// authorized сообщает, разрешают ли ACL-правила identity метод r.Method на пути
+// r.URL.Path.
+func authorized(identity Identity, r *http.Request, acls map[string][]ACLRule) bool {
+	return pathAuthorized(identity, r.URL.Path, r.Method, acls)
+}
+
+// pathAuthorized сообщает, разрешают ли ACL-правила identity вызвать method на
+// path. authorized вызывает ее с r.URL.Path/r.Method для обычных запросов;
+// bulk-обработчики (bulk_handlers.go) вызывают ее напрямую с path,
+// реконструированным из ключа, лежащего в теле запроса, а не в URL - ACL с
+// префиксом вида "/v1/keys/app1/" иначе были бы бесполезны для bulk-операций,
+// у которых путь запроса всегда "/v1/keys".
+func pathAuthorized(identity Identity, path, method string, acls map[string][]ACLRule) bool {
+	for _, rule := range acls[identity.Name] {
+		if !strings.HasPrefix(path, rule.Prefix) {
+			continue
+		}
+		for _, m := range rule.Methods {
+			if m == method {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keyAuthorized сообщает, разрешают ли ACL-правила identity вызвать method на
+// ключе key. Используется bulk-обработчиками (bulk_handlers.go), чтобы
+// проверить каждый ключ из тела bulk-запроса по отдельности - путь самого
+// запроса всегда "/v1/keys" и не годится для этой проверки.
+func keyAuthorized(identity Identity, key, method string) bool {
+	if identity.Admin {
+		return true
+	}
+	return pathAuthorized(identity, "/v1/keys/"+key, method, activeACLs)
+}
+
+// whoamiHandler обслуживает GET /v1/whoami, возвращая Identity, под которым
+// аутентифицирован текущий запрос.
+func whoamiHandler(w http.ResponseWriter, r *http.Request) {
+	identity, ok := identityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	j, err := json.Marshal(identity)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(j)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/gorilla/mux"
+)
+
+// bulkMu сериализует bulk PUT/DELETE-запросы друг относительно друга, чтобы
+// частичные записи одной bulk-операции никогда не перемежались с другой, и
+// чтобы откат при ошибке на середине пакета происходил раньше, чем другой
+// bulk-запрос увидит промежуточное состояние.
+var bulkMu sync.Mutex
+
+// bulkKeysAuthorized проверяет identity запроса r по ACL на каждый ключ из keys
+// по отдельности (см. keyAuthorized в auth.go). Требуется потому, что
+// authMiddleware авторизует запрос по r.URL.Path, а у bulk-запросов путь всегда
+// "/v1/keys" независимо от того, какие ключи лежат в теле - без этой проверки
+// любой, кому разрешен bulk-эндпоинт вообще, мог бы читать/писать чужие ключи
+// в обход ACL с префиксом. Если аутентификация отключена (identity в контексте
+// нет), проверка пропускается - как и для остальных обработчиков.
+func bulkKeysAuthorized(r *http.Request, keys []string) bool {
+	identity, ok := identityFromContext(r.Context())
+	if !ok {
+		return true
+	}
+	for _, key := range keys {
+		if !keyAuthorized(identity, key, r.Method) {
+			return false
+		}
+	}
+	return true
+}
+
+func keysOf(kvs map[string]string) []string {
+	keys := make([]string, 0, len(kvs))
+	for key := range kvs {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// priorKV - значение ключа до применения bulk-операции. Используется, чтобы
+// откатить уже примененные в этом запросе мутации, если одна из последующих
+// завершится ошибкой - иначе журнал транзакций (в который события пишутся
+// только после того, как весь пакет применился) разойдется с тем, что осталось
+// в памяти.
+type priorKV struct {
+	key     string
+	value   string
+	existed bool
+}
+
+func getPriorKV(key string) priorKV {
+	value, err := Get(key)
+	return priorKV{key: key, value: value, existed: !errors.Is(err, ErrorNoSuchKey)}
+}
+
+// rollback восстанавливает ключ в состояние, которое было до bulk-операции.
+func (p priorKV) rollback() {
+	if p.existed {
+		Put(p.key, p.value)
+	} else {
+		Delete(p.key)
+	}
+}
+
+func rollbackAll(applied []priorKV) {
+	for i := len(applied) - 1; i >= 0; i-- {
+		applied[i].rollback()
+	}
+}
+
+// keyValueBulkPutHandler ожидает получить POST-запрос с телом вида {key: value, ...}.
+// Пары применяются к хранилищу одна за другой, запоминая предыдущее значение
+// каждого ключа; если Put для какого-то ключа возвращает ошибку, все уже
+// примененные в этом запросе пары откатываются и WriteBatch не вызывается
+// вовсе - так лог никогда не расходится с памятью. Журнал транзакций
+// записывается одним сгруппированным вызовом WriteBatch только после того, как
+// весь пакет успешно применился.
+func keyValueBulkPutHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var kvs map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&kvs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !bulkKeysAuthorized(r, keysOf(kvs)) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	bulkMu.Lock()
+	defer bulkMu.Unlock()
+
+	applied := make([]priorKV, 0, len(kvs))
+	events := make([]Event, 0, len(kvs))
+	for key, value := range kvs {
+		prior := getPriorKV(key)
+		if err := Put(key, value); err != nil {
+			rollbackAll(applied)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		applied = append(applied, prior)
+		events = append(events, Event{EventType: EventPut, Key: key, Value: value})
+	}
+
+	logger.WriteBatch(events)
+	if snapshotter != nil {
+		snapshotter.Observe(len(events))
+	}
+	updateKeyCountMetric()
+	w.WriteHeader(http.StatusCreated)
+}
+
+// keyValueBulkDeleteHandler ожидает получить DELETE-запрос с телом - JSON-массивом
+// ключей. Как и keyValueBulkPutHandler, откатывает уже примененные удаления на
+// частичной ошибке и пишет в журнал транзакций одним WriteBatch только после
+// того, как весь пакет успешно применился.
+func keyValueBulkDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	var keys []string
+	if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !bulkKeysAuthorized(r, keys) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	bulkMu.Lock()
+	defer bulkMu.Unlock()
+
+	applied := make([]priorKV, 0, len(keys))
+	events := make([]Event, 0, len(keys))
+	for _, key := range keys {
+		prior := getPriorKV(key)
+		if err := Delete(key); err != nil {
+			rollbackAll(applied)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		applied = append(applied, prior)
+		events = append(events, Event{EventType: EventDelete, Key: key})
+	}
+
+	logger.WriteBatch(events)
+	if snapshotter != nil {
+		snapshotter.Observe(len(events))
+	}
+	updateKeyCountMetric()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// keyValuePatchHandler ожидает получить PATCH-запрос с ресурсом "/v1/keys/{key}"
+// и телом - документом RFC 6902 JSON Patch - который применяется к текущему
+// значению ключа.
+func keyValuePatchHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := vars["key"]
+
+	patchData, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	patch, err := jsonpatch.DecodePatch(patchData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	current, err := Get(key)
+	if errors.Is(err, ErrorNoSuchKey) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	patched, err := patch.Apply([]byte(current))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := Put(key, string(patched)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.WritePut(key, string(patched))
+	if snapshotter != nil {
+		snapshotter.Observe(1)
+	}
+	w.Write(patched)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config описывает то, какой бэкенд журнала транзакций использовать и как к нему
+// подключаться. Значения, загруженные из файла конфигурации, могут быть
+// переопределены переменными окружения KVSTORE_*, поэтому оператор может
+// переключить хранилище без перекомпиляции бинарника.
+type Config struct {
+	Backend  string           `json:"backend" yaml:"backend"`
+	File     FileDBParams     `json:"file" yaml:"file"`
+	Postgres PostgresDBParams `json:"postgres" yaml:"postgres"`
+	MySQL    MySQLDBParams    `json:"mysql" yaml:"mysql"`
+	Snapshot SnapshotConfig   `json:"snapshot" yaml:"snapshot"`
+	TLS      TLSConfig        `json:"tls" yaml:"tls"`
+	Auth     AuthConfig       `json:"auth" yaml:"auth"`
+}
+
+// TLSConfig задает материал TLS-сервера. CertFile/KeyFile включают
+// ListenAndServeTLS вместо обычного ListenAndServe; ClientCAFile дополнительно
+// включает mTLS, требуя от клиентов сертификат, подписанный этим CA.
+type TLSConfig struct {
+	CertFile     string `json:"cert_file" yaml:"cert_file"`
+	KeyFile      string `json:"key_file" yaml:"key_file"`
+	ClientCAFile string `json:"client_ca_file" yaml:"client_ca_file"`
+}
+
+// AuthConfig задает identity-провайдеры (bearer-токены и HTTP Basic) и
+// ACL-правила, проверяемые authMiddleware. Когда бэкенд - Postgres, ACLs и
+// Admins вместо этого могут загружаться из таблицы acls (см. PostgresTransactionLogger.LoadACLs);
+// значения из файла конфигурации в этом случае служат запасным вариантом.
+type AuthConfig struct {
+	Tokens     map[string]string    `json:"tokens" yaml:"tokens"`
+	BasicUsers map[string]string    `json:"basic_users" yaml:"basic_users"`
+	Admins     []string             `json:"admins" yaml:"admins"`
+	ACLs       map[string][]ACLRule `json:"acls" yaml:"acls"`
+}
+
+// SnapshotConfig управляет подсистемой периодических снимков/компакции (см.
+// snapshot.go). IntervalSeconds <= 0 отключает таймер; EveryNEvents <= 0
+// отключает срабатывание по счетчику событий. Если отключено и то, и другое,
+// снимок все равно можно запустить вручную через POST /v1/admin/snapshot.
+// Поддерживается только бэкендом, реализующим Snapshottable (сейчас - только
+// Postgres); для file-бэкенда компакция не реализована (см. Snapshottable в
+// snapshot.go).
+type SnapshotConfig struct {
+	IntervalSeconds int `json:"interval_seconds" yaml:"interval_seconds"`
+	EveryNEvents    int `json:"every_n_events" yaml:"every_n_events"`
+}
+
+// FileDBParams задает параметры файлового бэкенда журнала транзакций.
+type FileDBParams struct {
+	Path string `json:"path" yaml:"path"`
+}
+
+// LoadConfig читает конфигурацию из файла по пути path (JSON или YAML), а затем
+// накладывает на нее переменные окружения KVSTORE_*. Пустой path допустим —
+// в этом случае используются только значения по умолчанию и переменные окружения.
+func LoadConfig(path string) (Config, error) {
+	config := Config{
+		Backend: "file",
+		File:    FileDBParams{Path: "transaction.log"},
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return config, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return config, fmt.Errorf("failed to parse config file: %w", err)
+		}
+	}
+
+	applyEnvOverrides(&config)
+
+	return config, nil
+}
+
+// applyEnvOverrides переопределяет поля config значениями переменных окружения,
+// если те заданы. KVSTORE_BACKEND выбирает реализацию TransactionLogger,
+// остальные переменные задают параметры подключения к SQL-бэкендам.
+func applyEnvOverrides(config *Config) {
+	if backend := os.Getenv("KVSTORE_BACKEND"); backend != "" {
+		config.Backend = backend
+	}
+	if path := os.Getenv("KVSTORE_FILE_PATH"); path != "" {
+		config.File.Path = path
+	}
+	if host := os.Getenv("KVSTORE_HOST"); host != "" {
+		config.Postgres.Host = host
+		config.MySQL.Host = host
+	}
+	if dbName := os.Getenv("KVSTORE_DBNAME"); dbName != "" {
+		config.Postgres.DBName = dbName
+		config.MySQL.DBName = dbName
+	}
+	if user := os.Getenv("KVSTORE_USER"); user != "" {
+		config.Postgres.User = user
+		config.MySQL.User = user
+	}
+	if password := os.Getenv("KVSTORE_PASSWORD"); password != "" {
+		config.Postgres.Password = password
+		config.MySQL.Password = password
+	}
+	if sslmode := os.Getenv("KVSTORE_SSLMODE"); sslmode != "" {
+		config.Postgres.SSLMode = sslmode
+	}
+	if nodeID := os.Getenv("KVSTORE_NODE_ID"); nodeID != "" {
+		config.Postgres.NodeID = nodeID
+	}
+}
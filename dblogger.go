@@ -1,17 +1,29 @@
 package main
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	_ "github.com/lib/pq" // Анонимный импорт пакета драйвера
+	"github.com/lib/pq"
+	"log"
+	"strings"
 	"sync"
 )
 
 type PostgresTransactionLogger struct {
-	events chan<- Event    // Канал только для записи; для передачи событий
-	errors <-chan error    // Канал только для чтения; для приема ошибок
-	db     *sql.DB         // Интерфейс доступа к базе данных
-	wg     *sync.WaitGroup // для того, чтобы не потерять события
+	events  chan<- Event    // Канал только для записи; для передачи событий
+	batches chan<- []Event  // Канал только для записи; для передачи пакетов событий
+	errors  <-chan error    // Канал только для чтения; для приема ошибок
+	db      *sql.DB         // Интерфейс доступа к базе данных
+	wg      *sync.WaitGroup // для того, чтобы не потерять события
+
+	connStr   string     // DSN, используемый для открытия отдельного listener-соединения
+	nodeID    string     // Идентификатор узла; используется, чтобы игнорировать собственные NOTIFY
+	channel   string     // Имя канала LISTEN/NOTIFY, используемого для репликации
+	clustered bool       // Включает режим репликации через LISTEN/NOTIFY
+	replicas  chan Event // Канал, на который доставляются события, полученные от других узлов
 }
 
 func (l *PostgresTransactionLogger) WritePut(key, value string) {
@@ -22,10 +34,24 @@ func (l *PostgresTransactionLogger) WriteDelete(key string) {
 	l.wg.Add(1)
 	l.events <- Event{EventType: EventDelete, Key: key}
 }
+
+// WriteBatch записывает events одной сгруппированной транзакцией, чтобы bulk-операции
+// (см. keyValueBulkPutHandler/keyValueBulkDeleteHandler) реплицировались атомарно.
+func (l *PostgresTransactionLogger) WriteBatch(events []Event) {
+	l.wg.Add(1)
+	l.batches <- events
+}
 func (l *PostgresTransactionLogger) Err() <-chan error {
 	return l.errors
 }
 
+// Subscribe возвращает канал, на который Run() доставляет события Put/Delete,
+// реплицированные от других узлов кластера через LISTEN/NOTIFY. Если кластерный
+// режим выключен (Clustered == false), в канал ничего не пишется.
+func (l *PostgresTransactionLogger) Subscribe() <-chan Event {
+	return l.replicas
+}
+
 func (l *PostgresTransactionLogger) Wait() {
 	l.wg.Wait()
 }
@@ -36,15 +62,25 @@ func (l *PostgresTransactionLogger) Close() error {
 	if l.events != nil {
 		close(l.events) // Terminates Run loop and goroutine
 	}
+	if l.batches != nil {
+		close(l.batches)
+	}
 
 	return l.db.Close()
 }
 
+// PostgresDBParams описывает параметры подключения к Postgres. Теги json/yaml
+// позволяют загружать их напрямую из файла конфигурации (см. Config в config.go).
 type PostgresDBParams struct {
-	dbName   string
-	host     string
-	user     string
-	password string
+	DBName      string `json:"dbname" yaml:"dbname"`
+	Host        string `json:"host" yaml:"host"`
+	User        string `json:"user" yaml:"user"`
+	Password    string `json:"password" yaml:"password"`
+	SSLMode     string `json:"sslmode" yaml:"sslmode"`
+	SSLRootCert string `json:"sslrootcert" yaml:"sslrootcert"`
+	NodeID      string `json:"node_id" yaml:"node_id"`
+	Clustered   bool   `json:"clustered" yaml:"clustered"`
+	Channel     string `json:"channel" yaml:"channel"`
 }
 
 func (l *PostgresTransactionLogger) verifyTableExists() (bool, error) {
@@ -72,7 +108,8 @@ func (l *PostgresTransactionLogger) createTable() error {
 		sequence      BIGSERIAL PRIMARY KEY,
 		event_type    SMALLINT,
 		key 		  TEXT,
-		value         TEXT
+		value         TEXT,
+		node_id       TEXT
 	  );`
 
 	_, err = l.db.Exec(createQuery)
@@ -86,21 +123,71 @@ func (l *PostgresTransactionLogger) createTable() error {
 func (l *PostgresTransactionLogger) Run() {
 	events := make(chan Event, 16) // Создать канал событий
 	l.events = events
+	batches := make(chan []Event, 16) // Создать канал пакетов событий
+	l.batches = batches
 	errors := make(chan error, 1) // Создать канал ошибок
 	l.errors = errors
 	go func() { // Запрос INSERT
 		query := `INSERT INTO transactions
-(event_type, key, value)
-VALUES ($1, $2, $3)`
+(event_type, key, value, node_id)
+VALUES ($1, $2, $3, $4)`
 		for e := range events { // Извлечь следующее событие Event
+			transactionLogQueueDepth.Set(float64(len(events)))
 			_, err := l.db.Exec( // Выполнить запрос INSERT
 				query,
-				e.EventType, e.Key, e.Value)
+				e.EventType, e.Key, e.Value, l.nodeID)
 			if err != nil {
 				errors <- err
+				transactionLogWriteErrors.Inc()
+				continue
 			}
+			transactionLogEventsWritten.Inc()
 		}
 	}()
+	go func() { // Пакетная вставка через COPY для атомарной записи bulk-операций
+		for batch := range batches {
+			transactionLogQueueDepth.Set(float64(len(batches)))
+			if err := l.writeBatch(batch); err != nil {
+				errors <- err
+				transactionLogWriteErrors.Inc()
+				continue
+			}
+			transactionLogEventsWritten.Add(float64(len(batch)))
+		}
+	}()
+
+	if l.clustered {
+		go l.listenForReplicas()
+	}
+}
+
+// writeBatch вставляет events одной COPY-транзакцией (pq.CopyIn), так что реплей
+// либо применит весь пакет, либо ни одной его записи.
+func (l *PostgresTransactionLogger) writeBatch(events []Event) error {
+	txn, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := txn.Prepare(pq.CopyIn("transactions", "event_type", "key", "value", "node_id"))
+	if err != nil {
+		return err
+	}
+
+	for _, e := range events {
+		if _, err := stmt.Exec(e.EventType, e.Key, e.Value, l.nodeID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := stmt.Exec(); err != nil {
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+
+	return txn.Commit()
 }
 
 func (l *PostgresTransactionLogger) ReadEvents() (<-chan Event, <-chan error) {
@@ -136,9 +223,162 @@ ORDER BY sequence`
 	return outEvent, outError
 }
 
+func (l *PostgresTransactionLogger) verifySnapshotsTableExists() (bool, error) {
+	const table = "snapshots"
+
+	var result string
+
+	rows, err := l.db.Query(fmt.Sprintf("SELECT to_regclass('public.%s');", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() && result != table {
+		rows.Scan(&result)
+	}
+
+	return result == table, rows.Err()
+}
+
+func (l *PostgresTransactionLogger) createSnapshotsTable() error {
+	_, err := l.db.Exec(`CREATE TABLE snapshots (
+		sequence BIGINT PRIMARY KEY,
+		data     BYTEA
+	  );`)
+	return err
+}
+
+// Snapshot сохраняет data (обычно gzip'd JSON, см. Snapshotter в snapshot.go) как
+// снимок состояния на текущий максимальный sequence таблицы transactions, а затем
+// усекает из transactions все строки вплоть до этого sequence. Снимок и усечение
+// выполняются в одной транзакции, так что снимок никогда не ссылается на events,
+// которые уже были удалены.
+func (l *PostgresTransactionLogger) Snapshot(data []byte) error {
+	txn, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var sequence uint64
+	if err := txn.QueryRow(`SELECT COALESCE(MAX(sequence), 0) FROM transactions`).Scan(&sequence); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	if _, err := txn.Exec(`INSERT INTO snapshots (sequence, data) VALUES ($1, $2)`, sequence, data); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	if _, err := txn.Exec(`DELETE FROM transactions WHERE sequence <= $1`, sequence); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// LoadSnapshot возвращает данные последнего сохраненного снимка и sequence, на
+// котором он был сделан. Отсутствие снимков не является ошибкой - в этом случае
+// возвращается nil data и sequence 0.
+func (l *PostgresTransactionLogger) LoadSnapshot() (data []byte, sequence uint64, err error) {
+	row := l.db.QueryRow(`SELECT sequence, data FROM snapshots ORDER BY sequence DESC LIMIT 1`)
+	err = row.Scan(&sequence, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, sequence, nil
+}
+
+func (l *PostgresTransactionLogger) verifyACLsTableExists() (bool, error) {
+	const table = "acls"
+
+	var result string
+
+	rows, err := l.db.Query(fmt.Sprintf("SELECT to_regclass('public.%s');", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() && result != table {
+		rows.Scan(&result)
+	}
+
+	return result == table, rows.Err()
+}
+
+func (l *PostgresTransactionLogger) createACLsTable() error {
+	_, err := l.db.Exec(`CREATE TABLE acls (
+		identity TEXT,
+		prefix   TEXT,
+		methods  TEXT,
+		is_admin BOOLEAN NOT NULL DEFAULT FALSE
+	  );`)
+	return err
+}
+
+// LoadACLs читает таблицу acls и возвращает ACL-правила, сгруппированные по
+// identity, а также список identity, помеченных is_admin = true. Используется
+// authMiddleware (через опциональный интерфейс ACLSource, см. auth.go) вместо
+// правил из файла конфигурации, когда бэкенд - Postgres.
+func (l *PostgresTransactionLogger) LoadACLs() (map[string][]ACLRule, []string, error) {
+	rows, err := l.db.Query(`SELECT identity, prefix, methods, is_admin FROM acls`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	acls := make(map[string][]ACLRule)
+	var admins []string
+	seenAdmin := make(map[string]bool)
+
+	for rows.Next() {
+		var identity, prefix, methods string
+		var isAdmin bool
+		if err := rows.Scan(&identity, &prefix, &methods, &isAdmin); err != nil {
+			return nil, nil, err
+		}
+		acls[identity] = append(acls[identity], ACLRule{
+			Prefix:  prefix,
+			Methods: strings.Split(methods, ","),
+		})
+		if isAdmin && !seenAdmin[identity] {
+			admins = append(admins, identity)
+			seenAdmin[identity] = true
+		}
+	}
+
+	return acls, admins, rows.Err()
+}
+
+// generateNodeID возвращает случайный hex-идентификатор для использования в
+// качестве nodeID узла, когда включен clustered-режим, но postgres.node_id не
+// задан. Узлы, молча разделяющие нулевое значение nodeID, считали бы
+// реплицированные события других узлов своими же и никогда не сходились бы
+// (см. replayFrom).
+func generateNodeID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
 func NewPostgresTransactionLogger(config PostgresDBParams) (TransactionLogger, error) {
-	connStr := fmt.Sprintf("host=%s dbname=%s user=%s password=%s sslmode=disable",
-		config.host, config.dbName, config.user, config.password)
+	sslMode := config.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	connStr := fmt.Sprintf("host=%s dbname=%s user=%s password=%s sslmode=%s",
+		config.Host, config.DBName, config.User, config.Password, sslMode)
+	if config.SSLRootCert != "" {
+		connStr += fmt.Sprintf(" sslrootcert=%s", config.SSLRootCert)
+	}
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open db: %w", err)
@@ -147,7 +387,27 @@ func NewPostgresTransactionLogger(config PostgresDBParams) (TransactionLogger, e
 	if err != nil {
 		return nil, fmt.Errorf("failed to open db connection: %w", err)
 	}
-	logger := &PostgresTransactionLogger{db: db, wg: &sync.WaitGroup{}}
+	channel := config.Channel
+	if channel == "" {
+		channel = "kv_events"
+	}
+	nodeID := config.NodeID
+	if config.Clustered && nodeID == "" {
+		nodeID, err = generateNodeID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate node id: %w", err)
+		}
+		log.Printf("postgres: clustered mode enabled but node_id was not configured; generated %s", nodeID)
+	}
+	logger := &PostgresTransactionLogger{
+		db:        db,
+		wg:        &sync.WaitGroup{},
+		connStr:   connStr,
+		nodeID:    nodeID,
+		channel:   channel,
+		clustered: config.Clustered,
+		replicas:  make(chan Event, 16),
+	}
 	exists, err := logger.verifyTableExists()
 	if err != nil {
 		return nil, fmt.Errorf("failed to verify table exists: %w", err)
@@ -157,5 +417,28 @@ func NewPostgresTransactionLogger(config PostgresDBParams) (TransactionLogger, e
 			return nil, fmt.Errorf("failed to create table: %w", err)
 		}
 	}
+	snapshotsExist, err := logger.verifySnapshotsTableExists()
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify snapshots table exists: %w", err)
+	}
+	if !snapshotsExist {
+		if err = logger.createSnapshotsTable(); err != nil {
+			return nil, fmt.Errorf("failed to create snapshots table: %w", err)
+		}
+	}
+	aclsExist, err := logger.verifyACLsTableExists()
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify acls table exists: %w", err)
+	}
+	if !aclsExist {
+		if err = logger.createACLsTable(); err != nil {
+			return nil, fmt.Errorf("failed to create acls table: %w", err)
+		}
+	}
+	if logger.clustered {
+		if err = logger.installReplicationTrigger(); err != nil {
+			return nil, fmt.Errorf("failed to install replication trigger: %w", err)
+		}
+	}
 	return logger, nil
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Метрики, экспортируемые на /metrics. httpRequestsTotal/httpRequestDuration
+// собираются в metricsMiddleware для каждого обработчика; остальные обновляются
+// там, где, собственно, происходят соответствующие события - в реализациях
+// TransactionLogger, в обработчиках, мутирующих хранилище, и в
+// initializeTransactionLog (replay журнала при старте).
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kvstore_http_requests_total",
+		Help: "Total number of HTTP requests, partitioned by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kvstore_http_request_duration_seconds",
+		Help:    "Latency of HTTP requests, partitioned by route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	transactionLogEventsWritten = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "transaction_log_events_written_total",
+		Help: "Total number of events written to the transaction log.",
+	})
+
+	transactionLogReplayDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "transaction_log_replay_duration_seconds",
+		Help: "Time spent replaying the transaction log on startup.",
+	})
+
+	transactionLogWriteErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "transaction_log_write_errors_total",
+		Help: "Total number of errors encountered while writing to the transaction log.",
+	})
+
+	transactionLogQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "transaction_log_queue_depth",
+		Help: "Items waiting in the transaction log write queue, as last observed by a writer goroutine: buffered single events, or buffered event batches for the batch writer.",
+	})
+
+	kvStoreKeys = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kv_store_keys",
+		Help: "Current number of keys held in the in-memory store.",
+	})
+)
+
+// accessLog - структурированный (zap) лог доступа; используется metricsMiddleware
+// наряду с метриками Prometheus.
+var accessLog = newAccessLogger()
+
+func newAccessLogger() *zap.Logger {
+	logger, err := zap.NewProduction()
+	if err != nil {
+		return zap.NewNop()
+	}
+	return logger
+}
+
+// updateKeyCountMetric пересчитывает gauge kv_store_keys по текущему содержимому
+// хранилища. Вызывается из обработчиков PUT/DELETE и bulk-обработчиков после
+// успешной мутации, а также после replay в initializeTransactionLog; PATCH
+// (число ключей не меняет) и события репликации его не вызывают.
+func updateKeyCountMetric() {
+	value, err := GetAll()
+	if err != nil {
+		return
+	}
+	kvStoreKeys.Set(float64(len(value)))
+}
+
+// statusRecorder оборачивает http.ResponseWriter, чтобы перехватить код статуса,
+// который net/http иначе не отдает обработчику middleware.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// metricsMiddleware оборачивает mux.Router, записывая количество запросов,
+// задержку и код статуса для каждого обработчика как метрики Prometheus, а также
+// пишет структурированную запись в access-лог.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		duration := time.Since(start)
+		route := requestRoute(r)
+		status := strconv.Itoa(rec.status)
+
+		httpRequestsTotal.WithLabelValues(route, r.Method, status).Inc()
+		httpRequestDuration.WithLabelValues(route, r.Method).Observe(duration.Seconds())
+
+		accessLog.Info("http request",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.String("route", route),
+			zap.Int("status", rec.status),
+			zap.Duration("duration", duration),
+		)
+	})
+}
+
+// requestRoute возвращает шаблон маршрута mux (например, "/v1/keys/{key}"), а не
+// буквальный путь запроса, чтобы метрики не дробились по значению ключа.
+func requestRoute(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return r.URL.Path
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	_ "github.com/go-sql-driver/mysql" // Анонимный импорт пакета драйвера
+)
+
+// MySQLTransactionLogger - реализация TransactionLogger поверх MySQL. Одиночные
+// события (WritePut/WriteDelete) пишутся, как и в PostgresTransactionLogger, через
+// буферизованный канал в отдельной горутине, запущенной из Run(); WriteBatch же
+// выполняет вставку синхронно в вызывающей горутине. Репликации через
+// LISTEN/NOTIFY, снимков и таблицы acls у этого бэкенда нет.
+type MySQLTransactionLogger struct {
+	events chan<- Event    // Канал только для записи; для передачи событий
+	errors <-chan error    // Канал только для чтения; для приема ошибок
+	db     *sql.DB         // Интерфейс доступа к базе данных
+	wg     *sync.WaitGroup // для того, чтобы не потерять события
+}
+
+// MySQLDBParams описывает параметры подключения к MySQL. Теги json/yaml позволяют
+// загружать их напрямую из файла конфигурации (см. Config в config.go).
+type MySQLDBParams struct {
+	DBName   string `json:"dbname" yaml:"dbname"`
+	Host     string `json:"host" yaml:"host"`
+	User     string `json:"user" yaml:"user"`
+	Password string `json:"password" yaml:"password"`
+}
+
+func (l *MySQLTransactionLogger) WritePut(key, value string) {
+	l.wg.Add(1)
+	l.events <- Event{EventType: EventPut, Key: key, Value: value}
+}
+func (l *MySQLTransactionLogger) WriteDelete(key string) {
+	l.wg.Add(1)
+	l.events <- Event{EventType: EventDelete, Key: key}
+}
+
+// WriteBatch вставляет events одной транзакцией, чтобы bulk-операции применялись
+// атомарно при replay.
+func (l *MySQLTransactionLogger) WriteBatch(events []Event) {
+	l.wg.Add(1)
+	txn, err := l.db.Begin()
+	if err != nil {
+		return
+	}
+	query := `INSERT INTO transactions (event_type, key_name, value) VALUES (?, ?, ?)`
+	for _, e := range events {
+		if _, err := txn.Exec(query, e.EventType, e.Key, e.Value); err != nil {
+			txn.Rollback()
+			return
+		}
+	}
+	txn.Commit()
+}
+
+func (l *MySQLTransactionLogger) Err() <-chan error {
+	return l.errors
+}
+
+// Subscribe возвращает nil - MySQLTransactionLogger пока не поддерживает
+// кластерную репликацию в отличие от PostgresTransactionLogger.
+func (l *MySQLTransactionLogger) Subscribe() <-chan Event {
+	return nil
+}
+
+func (l *MySQLTransactionLogger) Wait() {
+	l.wg.Wait()
+}
+
+func (l *MySQLTransactionLogger) Close() error {
+	l.wg.Wait()
+
+	if l.events != nil {
+		close(l.events) // Terminates Run loop and goroutine
+	}
+
+	return l.db.Close()
+}
+
+func (l *MySQLTransactionLogger) verifyTableExists() (bool, error) {
+	const table = "transactions"
+
+	var result string
+
+	rows, err := l.db.Query("SHOW TABLES LIKE ?", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() && result != table {
+		rows.Scan(&result)
+	}
+
+	return result == table, rows.Err()
+}
+
+func (l *MySQLTransactionLogger) createTable() error {
+	var err error
+
+	createQuery := `CREATE TABLE transactions (
+		sequence      BIGINT PRIMARY KEY AUTO_INCREMENT,
+		event_type    SMALLINT,
+		key_name      TEXT,
+		value         TEXT
+	  );`
+
+	_, err = l.db.Exec(createQuery)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (l *MySQLTransactionLogger) Run() {
+	events := make(chan Event, 16) // Создать канал событий
+	l.events = events
+	errors := make(chan error, 1) // Создать канал ошибок
+	l.errors = errors
+	go func() { // Запрос INSERT
+		query := `INSERT INTO transactions
+(event_type, key_name, value)
+VALUES (?, ?, ?)`
+		for e := range events { // Извлечь следующее событие Event
+			transactionLogQueueDepth.Set(float64(len(events)))
+			_, err := l.db.Exec( // Выполнить запрос INSERT
+				query,
+				e.EventType, e.Key, e.Value)
+			if err != nil {
+				errors <- err
+				transactionLogWriteErrors.Inc()
+				continue
+			}
+			transactionLogEventsWritten.Inc()
+		}
+	}()
+}
+
+func (l *MySQLTransactionLogger) ReadEvents() (<-chan Event, <-chan error) {
+	outEvent := make(chan Event)    // Небуферизованный канал событий
+	outError := make(chan error, 1) // Буферизованный канал ошибок
+	go func() {
+		defer close(outEvent) // Закрыть каналы
+		defer close(outError) // по завершении сопрограммы
+		query := `SELECT sequence, event_type, key_name, value FROM transactions
+ORDER BY sequence`
+		rows, err := l.db.Query(query) // Выполнить запрос; получить набор результатов
+		if err != nil {
+			outError <- fmt.Errorf("sql query error: %w", err)
+			return
+		}
+		defer rows.Close() // Это важно!
+		e := Event{}       // Создать пустой экземпляр Event
+		for rows.Next() {  // Цикл по записям
+			err = rows.Scan( // Прочитать значения
+				&e.Sequence, &e.EventType, // из записи в Event.
+				&e.Key, &e.Value)
+			if err != nil {
+				outError <- fmt.Errorf("error reading row: %w", err)
+				return
+			}
+			outEvent <- e // Послать e в канал
+		}
+		err = rows.Err()
+		if err != nil {
+			outError <- fmt.Errorf("transaction log read failure: %w", err)
+		}
+	}()
+	return outEvent, outError
+}
+
+func NewMySQLTransactionLogger(config MySQLDBParams) (TransactionLogger, error) {
+	connStr := fmt.Sprintf("%s:%s@tcp(%s)/%s",
+		config.User, config.Password, config.Host, config.DBName)
+	db, err := sql.Open("mysql", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open db: %w", err)
+	}
+	err = db.Ping() // Проверка соединения с базой данных
+	if err != nil {
+		return nil, fmt.Errorf("failed to open db connection: %w", err)
+	}
+	logger := &MySQLTransactionLogger{db: db, wg: &sync.WaitGroup{}}
+	exists, err := logger.verifyTableExists()
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify table exists: %w", err)
+	}
+	if !exists {
+		if err = logger.createTable(); err != nil {
+			return nil, fmt.Errorf("failed to create table: %w", err)
+		}
+	}
+	return logger, nil
+}
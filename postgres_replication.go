@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// installReplicationTrigger устанавливает на таблицу transactions триггер, который
+// рассылает NOTIFY <channel>, <sequence> при каждой вставке новой строки. Остальные
+// узлы кластера слушают этот канал через listenForReplicas и подтягивают новые строки.
+func (l *PostgresTransactionLogger) installReplicationTrigger() error {
+	functionQuery := fmt.Sprintf(`CREATE OR REPLACE FUNCTION notify_%s() RETURNS trigger AS $$
+	BEGIN
+		PERFORM pg_notify('%s', NEW.sequence::text);
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;`, l.channel, l.channel)
+	if _, err := l.db.Exec(functionQuery); err != nil {
+		return err
+	}
+
+	triggerQuery := fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_trigger ON transactions;
+	CREATE TRIGGER %s_trigger AFTER INSERT ON transactions
+	FOR EACH ROW EXECUTE PROCEDURE notify_%s();`, l.channel, l.channel, l.channel)
+	_, err := l.db.Exec(triggerQuery)
+	return err
+}
+
+// listenForReplicas подписывается на канал LISTEN/NOTIFY и применяет события,
+// вставленные другими узлами, к локальному хранилищу в памяти через Put/Delete.
+// События, пришедшие от собственного узла (nodeID), пропускаются, чтобы не
+// применять их дважды.
+func (l *PostgresTransactionLogger) listenForReplicas() {
+	reportProblem := func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("replication listener: %v", err)
+		}
+	}
+
+	listener := pq.NewListener(l.connStr, 10*time.Second, time.Minute, reportProblem)
+	if err := listener.Listen(l.channel); err != nil {
+		return
+	}
+	defer listener.Close()
+
+	lastSeen := int64(0)
+	for n := range listener.Notify {
+		if n == nil { // Переподключение: просто ждем следующего NOTIFY
+			continue
+		}
+		sequence, err := strconv.ParseInt(n.Extra, 10, 64)
+		if err != nil {
+			continue
+		}
+		l.replayFrom(lastSeen, sequence)
+		lastSeen = sequence
+	}
+}
+
+// replayFrom загружает строки с sequence в (after, through] и применяет их
+// к локальному хранилищу, пропуская события, сгенерированные этим узлом.
+func (l *PostgresTransactionLogger) replayFrom(after, through int64) {
+	rows, err := l.db.Query(
+		`SELECT sequence, event_type, key, value, node_id FROM transactions
+		 WHERE sequence > $1 AND sequence <= $2 ORDER BY sequence`,
+		after, through)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e Event
+		var nodeID string
+		if err := rows.Scan(&e.Sequence, &e.EventType, &e.Key, &e.Value, &nodeID); err != nil {
+			continue
+		}
+		if nodeID == l.nodeID {
+			continue // Это наше собственное событие, уже применено локально
+		}
+		switch e.EventType {
+		case EventPut:
+			Put(e.Key, e.Value)
+		case EventDelete:
+			Delete(e.Key)
+		}
+		select {
+		case l.replicas <- e:
+		default:
+		}
+	}
+}
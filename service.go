@@ -1,16 +1,22 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"time"
 )
 
 var logger TransactionLogger
+var snapshotter *Snapshotter
 
 func keyValuePutHandler(w http.ResponseWriter, r *http.Request) {
 	// keyValuePutHandler ожидает получить PUT-запрос с
@@ -37,6 +43,10 @@ func keyValuePutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	logger.WritePut(key, string(value))
+	if snapshotter != nil {
+		snapshotter.Observe(1)
+	}
+	updateKeyCountMetric()
 	w.WriteHeader(http.StatusCreated) // Все хорошо! Вернуть StatusCreated
 }
 
@@ -68,6 +78,10 @@ func keyValueDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	logger.WriteDelete(key)
+	if snapshotter != nil {
+		snapshotter.Observe(1)
+	}
+	updateKeyCountMetric()
 	w.WriteHeader(http.StatusNoContent) // Все хорошо! Вернуть StatusNoContent
 }
 
@@ -88,12 +102,41 @@ func keyValueGetAllKeysHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(j) // Записать значение в ответ
 }
 
-func initializeTransactionLog() error {
+// snapshotHandler обслуживает POST /v1/admin/snapshot, запуская снимок немедленно,
+// не дожидаясь следующего тика периодического таймера Snapshotter.
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if snapshotter == nil {
+		http.Error(w, "snapshotting is not supported by this backend", http.StatusNotImplemented)
+		return
+	}
+	if err := snapshotter.Snapshot(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func initializeTransactionLog(config Config) error {
 	var err error
-	logger, err = NewFileTransactionLogger("transaction.log")
+	logger, err = NewTransactionLogger(config.Backend, config)
 	if err != nil {
 		return fmt.Errorf("failed to create event logger: %w", err)
 	}
+
+	if snappable, ok := logger.(Snapshottable); ok {
+		data, sequence, err := snappable.LoadSnapshot()
+		if err != nil {
+			return fmt.Errorf("failed to load snapshot: %w", err)
+		}
+		if data != nil {
+			if err := restoreSnapshot(data); err != nil {
+				return fmt.Errorf("failed to restore snapshot: %w", err)
+			}
+			log.Printf("restored snapshot at sequence %d", sequence)
+		}
+	}
+
+	replayStart := time.Now()
 	events, errors := logger.ReadEvents()
 	e, ok := Event{}, true
 	for ok && err == nil {
@@ -108,22 +151,100 @@ func initializeTransactionLog() error {
 			}
 		}
 	}
-	logger.Run()
-	defer logger.Close()
+	transactionLogReplayDuration.Observe(time.Since(replayStart).Seconds())
+	updateKeyCountMetric()
 	return err
 }
 
 func main() {
-	err := initializeTransactionLog()
+	config, err := LoadConfig(os.Getenv("KVSTORE_CONFIG"))
 	if err != nil {
-		return
+		log.Fatal(err)
 	}
+
+	err = initializeTransactionLog(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger.Run()
+	defer logger.Close()
+
+	interval := time.Duration(config.Snapshot.IntervalSeconds) * time.Second
+	if s, ok := NewSnapshotter(logger, interval, uint64(config.Snapshot.EveryNEvents)); ok {
+		snapshotter = s
+		snapshotter.Run()
+	} else if config.Snapshot.IntervalSeconds > 0 {
+		// Snapshottable сейчас реализован только у PostgresTransactionLogger (см.
+		// snapshot.go); без этого предупреждения заданный snapshot.interval_seconds
+		// на другом бэкенде молча никогда бы не срабатывал.
+		log.Printf("snapshot.interval_seconds задан, но бэкенд %q не поддерживает снимки; снимки делаться не будут", config.Backend)
+	}
+
+	auth := config.Auth
+	if aclSource, ok := logger.(ACLSource); ok {
+		acls, admins, err := aclSource.LoadACLs()
+		if err != nil {
+			log.Fatal(fmt.Errorf("failed to load ACLs: %w", err))
+		}
+		if len(acls) > 0 || len(admins) > 0 {
+			auth.ACLs = acls
+			auth.Admins = admins
+		}
+	}
+
+	activeACLs = auth.ACLs
+
 	r := mux.NewRouter()
 	// Зарегистрировать keyValuePutHandler как обработчик HTTP-запросов PUT,
 	//в которых указан путь "/v1/{key}"
 	r.HandleFunc("/v1/keys/{key}", keyValuePutHandler).Methods("PUT")
 	r.HandleFunc("/v1/keys/{key}", keyValueGetHandler).Methods("GET")
 	r.HandleFunc("/v1/keys/{key}", keyValueDeleteHandler).Methods("DELETE")
+	r.HandleFunc("/v1/keys/{key}", keyValuePatchHandler).Methods("PATCH")
 	r.HandleFunc("/v1/keys", keyValueGetAllKeysHandler).Methods("GET")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	r.HandleFunc("/v1/keys", keyValueBulkPutHandler).Methods("POST")
+	r.HandleFunc("/v1/keys", keyValueBulkDeleteHandler).Methods("DELETE")
+	r.HandleFunc("/v1/admin/snapshot", snapshotHandler).Methods("POST")
+	r.HandleFunc("/v1/whoami", whoamiHandler).Methods("GET")
+	r.Handle("/metrics", promhttp.Handler())
+	r.Use(metricsMiddleware)
+	if len(auth.Tokens) > 0 || len(auth.BasicUsers) > 0 {
+		r.Use(func(next http.Handler) http.Handler {
+			return authMiddleware(auth, next)
+		})
+	}
+
+	server := &http.Server{Addr: ":8080", Handler: r}
+	if err := serve(server, config.TLS); err != nil {
+		// Не log.Fatal: тот вызвал бы os.Exit до того, как выполнятся defer'ы
+		// выше (logger.Run()'s defer logger.Close()), роняя на пол еще не
+		// сброшенные в журнал буферизованные события.
+		log.Print(err)
+	}
+}
+
+// serve запускает server обычным HTTP, TLS или mTLS - в зависимости от tlsConfig.
+// Заданный ClientCAFile включает mTLS, требуя от каждого клиента сертификат,
+// подписанный этим CA.
+func serve(server *http.Server, tlsConfig TLSConfig) error {
+	if tlsConfig.CertFile == "" || tlsConfig.KeyFile == "" {
+		return server.ListenAndServe()
+	}
+
+	if tlsConfig.ClientCAFile != "" {
+		caCert, err := os.ReadFile(tlsConfig.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caCert) {
+			return fmt.Errorf("failed to parse client CA file: %s", tlsConfig.ClientCAFile)
+		}
+		server.TLSConfig = &tls.Config{
+			ClientCAs:  caPool,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+		}
+	}
+
+	return server.ListenAndServeTLS(tlsConfig.CertFile, tlsConfig.KeyFile)
 }
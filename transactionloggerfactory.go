@@ -0,0 +1,31 @@
+package main
+
+import "fmt"
+
+// TransactionLoggerFactory строит TransactionLogger из конфигурации одного бэкенда.
+type TransactionLoggerFactory func(config Config) (TransactionLogger, error)
+
+// transactionLoggerFactories - реестр фабрик, зарегистрированных по имени бэкенда
+// (значение Config.Backend / переменной окружения KVSTORE_BACKEND).
+var transactionLoggerFactories = map[string]TransactionLoggerFactory{
+	"file": func(config Config) (TransactionLogger, error) {
+		return NewFileTransactionLogger(config.File.Path)
+	},
+	"postgres": func(config Config) (TransactionLogger, error) {
+		return NewPostgresTransactionLogger(config.Postgres)
+	},
+	"mysql": func(config Config) (TransactionLogger, error) {
+		return NewMySQLTransactionLogger(config.MySQL)
+	},
+}
+
+// NewTransactionLogger возвращает TransactionLogger, зарегистрированный под именем
+// backend, передавая ему соответствующую часть config. Это позволяет выбирать
+// бэкенд хранения во время выполнения, а не во время компиляции.
+func NewTransactionLogger(backend string, config Config) (TransactionLogger, error) {
+	factory, ok := transactionLoggerFactories[backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown transaction log backend: %q", backend)
+	}
+	return factory(config)
+}